@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/quaiclient/ethclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	bolt "go.etcd.io/bbolt"
+)
+
+// progressBucket 是 BoltDB 中持久化各链/分片回填进度（最后已处理区块高度）的 bucket 名称。
+const progressBucket = "backfill_progress"
+
+// stateStore 将各链/分片已回填到的最后区块高度持久化到一个小型 BoltDB 文件中，
+// 使 -catchup 模式可以在进程重启后从上次中断的位置继续，而不是从头回填。
+type stateStore struct {
+	db *bolt.DB
+}
+
+// openStateStore 打开（或创建）路径为 path 的 BoltDB 文件。
+func openStateStore(path string) (*stateStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开回填进度文件 %s 失败：%v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(progressBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化回填进度 bucket 失败：%v", err)
+	}
+	return &stateStore{db: db}, nil
+}
+
+// lastSeen 返回某条链已持久化的最后区块高度；found 为 false 表示此前从未记录过。
+func (s *stateStore) lastSeen(chain string) (height uint64, found bool, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(progressBucket)).Get([]byte(chain))
+		if v == nil {
+			return nil
+		}
+		found = true
+		height = binary.BigEndian.Uint64(v)
+		return nil
+	})
+	return height, found, err
+}
+
+// setLastSeen 持久化某条链已处理到的区块高度。
+func (s *stateStore) setLastSeen(chain string, height uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, height)
+		return tx.Bucket([]byte(progressBucket)).Put([]byte(chain), buf)
+	})
+}
+
+func (s *stateStore) Close() error {
+	return s.db.Close()
+}
+
+// backfillConfig 收集驱动一次历史回填所需的参数，避免 backfillChain 的签名无限增长。
+type backfillConfig struct {
+	from        int64
+	catchup     bool
+	store       *stateStore
+	workers     int
+	maxBackoff  time.Duration
+	pushGateway string
+}
+
+// backfillChain 从给定的起始高度（或 BoltDB 中持久化的上次高度）开始，
+// 以一个有限大小的 worker 池并发拉取区块头，直到追上当前链高度为止；
+// 仅在 -catchup 模式下会跳过已持久化为回填过的高度，只指定 -from 时总是从 -from
+// 开始抓取，即使这些高度此前已回填过。回填范围可能达到百万级区块，因此单个区块
+// 的难度只记录进 difficultyHist（按链打标签的直方图），不写入按区块号打标签
+// 的 Gauge，也不逐块推送到 Pushgateway；完成后只推送一次最终状态。
+func backfillChain(chain string, client *ethclient.Client, cfg backfillConfig, m *metrics, difficultyHist *prometheus.HistogramVec) error {
+	if cfg.from < 0 && !cfg.catchup {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	tip, err := fetchBlockNumber(ctx, chain, client, cfg.maxBackoff)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("获取回填终点高度失败：%v", err)
+	}
+
+	start := uint64(0)
+	if cfg.from >= 0 {
+		start = uint64(cfg.from)
+	}
+	if cfg.catchup && cfg.store != nil {
+		last, found, err := cfg.store.lastSeen(chain)
+		if err != nil {
+			log.Printf("[%s] 读取回填进度失败，忽略并从 -from 指定的高度开始：%v", chain, err)
+		} else if found && last+1 > start {
+			start = last + 1
+		}
+	}
+	if start > tip {
+		log.Printf("[%s] 无需回填：起点 %d 已超过当前高度 %d", chain, start, tip)
+		return nil
+	}
+
+	total := float64(tip-start) + 1
+	log.Printf("[%s] 开始回填区块 %d 到 %d（共 %.0f 个区块，%d 个并发 worker）", chain, start, tip, total, cfg.workers)
+
+	heights := make(chan uint64)
+	var wg sync.WaitGroup
+	var completed uint64
+	var progressMu sync.Mutex
+
+	// worker 池里的 worker 会乱序完成高度（RPC 延迟各不相同），因此不能直接把
+	// 刚完成的 height 写成 last-seen：较高的 height 可能先于仍在处理中的较低
+	// height 落盘，一旦此时进程被杀掉，-catchup 会把 start 定到 last+1，导致
+	// 那些仍在飞行中的较低 height 被永久跳过。markDone 维护已完成高度的集合，
+	// 只把从 start 开始连续完成的最高 height 持久化为 last-seen。拉取失败的 height
+	// 同样会被 markDone 记为完成，因此之后的 -catchup 不会再重试它们。
+	var stateMu sync.Mutex
+	pending := make(map[uint64]bool)
+	nextExpected := start
+	markDone := func(height uint64) {
+		if cfg.store == nil {
+			return
+		}
+		stateMu.Lock()
+		defer stateMu.Unlock()
+		pending[height] = true
+		for pending[nextExpected] {
+			delete(pending, nextExpected)
+			if err := cfg.store.setLastSeen(chain, nextExpected); err != nil {
+				log.Printf("[%s] 持久化回填进度失败：%v", chain, err)
+			}
+			nextExpected++
+		}
+	}
+
+	worker := func() {
+		defer wg.Done()
+		for height := range heights {
+			hctx, hcancel := context.WithTimeout(context.Background(), 15*time.Second)
+			header, err := fetchHeader(hctx, chain, client, new(big.Int).SetUint64(height), cfg.maxBackoff)
+			hcancel()
+			if err != nil {
+				log.Printf("[%s] 回填区块 %d 失败：%v", chain, height, err)
+				markDone(height)
+				continue
+			}
+			if difficulty := header.Difficulty(); difficulty != nil {
+				difficultyHist.WithLabelValues(chain).Observe(float64(difficulty.Uint64()))
+			}
+			markDone(height)
+
+			progressMu.Lock()
+			completed++
+			ratio := float64(completed) / total
+			progressMu.Unlock()
+			m.backfillProgress.WithLabelValues(chain).Set(ratio)
+		}
+	}
+
+	for i := 0; i < cfg.workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	for height := start; height <= tip; height++ {
+		heights <- height
+	}
+	close(heights)
+	wg.Wait()
+
+	if cfg.pushGateway != "" {
+		// difficultyHist、m.backfillProgress 都是所有链共用的 Vec，Grouping 只决定
+		// Pushgateway 里的分组 key，不会过滤样本；直接推送整个 Vec 会让每条链的
+		// 推送都带上其它所有链的样本。只推送这条链自己的标签实例。
+		hist, ok := difficultyHist.WithLabelValues(chain).(prometheus.Histogram)
+		if !ok {
+			log.Printf("[%s] 内部错误：无法获取按链维度的难度直方图，跳过本次推送", chain)
+		} else if err := push.New(cfg.pushGateway, "quai_backfill_difficulty").
+			Collector(hist).
+			Collector(m.backfillProgress.WithLabelValues(chain)).
+			Grouping("job", "quai").
+			Grouping("chain", chain).
+			Push(); err != nil {
+			log.Printf("[%s] 推送回填结果失败：%v", chain, err)
+		}
+	}
+
+	log.Printf("[%s] 回填完成，已处理至区块 %d", chain, tip)
+	return nil
+}
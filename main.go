@@ -6,122 +6,546 @@ import (
 	"fmt"
 	"log"
 	"math/big"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/cenkalti/backoff/v4"
+	"github.com/dominant-strategies/go-quai/core/types"
 	"github.com/dominant-strategies/go-quai/quaiclient/ethclient"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/client_golang/prometheus/push"
 )
 
-// connectRPC 尝试连接到 RPC，并实现重试机制
-func connectRPC(rpcURL string) (*ethclient.Client, error) {
+// buildVersion 在编译时可通过 -ldflags "-X main.buildVersion=..." 注入，默认值用于本地构建。
+var buildVersion = "dev"
+
+// lastSuccessfulPoll 按链/分片记录最近一次成功完成一轮采集（获取区块号+区块头成功）的
+// Unix 时间戳，用于在 Prometheus 中设置“某条链采集已停滞”告警；若不按链打标签，
+// 任意一条健康链的持续采集会不断刷新这个时间戳，掩盖其他分片已停滞数小时的事实。
+var lastSuccessfulPoll = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "quai_monitor_last_successful_poll_timestamp_seconds",
+	Help: "最近一次成功完成采集的 Unix 时间戳（秒），按链/分片打标签",
+}, []string{"chain"})
+
+// buildInfo 暴露构建版本信息，值恒为 1，实际信息承载在标签上，是 Prometheus exporter 的惯用做法。
+var buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "quai_monitor_build_info",
+	Help: "监控程序的构建信息，标签 version 携带版本号，值恒为 1",
+}, []string{"version"})
+
+func init() {
+	prometheus.MustRegister(lastSuccessfulPoll, buildInfo)
+	prometheus.MustRegister(collectors.NewGoCollector())
+	prometheus.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	buildInfo.WithLabelValues(buildVersion).Set(1)
+}
+
+// serveMetrics 启动一个暴露 promhttp.Handler() 的 HTTP 服务，作为 Pushgateway 的替代方案：
+// Pushgateway 模型不适合长期运行的采集器（进程退出后指标会变成 stale sample），
+// 而拉模式天然带有 up/scrape_duration 语义，且可以像普通 exporter 一样接入
+// kube-prometheus-stack。
+func serveMetrics(listen string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Printf("在 %s 上暴露 /metrics 供 Prometheus 抓取", listen)
+	if err := http.ListenAndServe(listen, mux); err != nil {
+		log.Fatalf("启动 /metrics HTTP 服务失败：%v", err)
+	}
+}
+
+// redialThreshold 是单条链连续调用失败达到该次数后，主动关闭并重新拨号底层连接的阈值，
+// 避免无限期复用一个已经失效的连接。
+const redialThreshold = 3
+
+// rpcRetriesTotal 记录每条链在拨号、获取区块号、获取区块头时触发的重试次数，
+// 便于在 Grafana 上观测某条链的 RPC 是否不稳定。
+var rpcRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "quai_monitor_rpc_retries_total",
+	Help: "按链/分片与操作类型统计的 RPC 重试总次数",
+}, []string{"chain", "op"})
+
+func init() {
+	prometheus.MustRegister(rpcRetriesTotal)
+}
+
+// newExponentialBackOff 构造一个起始 500ms、倍增至 maxInterval 封顶、带 ±20% 抖动
+// 且不设总耗时上限的退避策略，供 RetryNotify 驱动的重试循环使用。
+func newExponentialBackOff(maxInterval time.Duration) *backoff.ExponentialBackOff {
+	b := backoff.NewExponentialBackOff()
+	b.InitialInterval = 500 * time.Millisecond
+	b.Multiplier = 2
+	b.RandomizationFactor = 0.2
+	b.MaxInterval = maxInterval
+	b.MaxElapsedTime = 0 // 不限制总耗时，由调用方决定何时放弃（例如 context 超时）
+	return b
+}
+
+// isFatalRPCError 判断一个错误是否为不值得重试的致命错误，例如鉴权失败或 URL 格式错误；
+// 其余（网络中断、超时、5xx 等）一律视为可重试的瞬时错误。匹配的是完整短语而非裸数字，
+// 因为像 "401"/"403" 这样的数字序列会普遍出现在带端口号的 dial 错误或区块高度里，
+// 裸数字匹配会把这类瞬时错误误判为致命错误。
+func isFatalRPCError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	fatalSubstrings := []string{
+		"unauthorized",
+		"forbidden",
+		"401 unauthorized",
+		"403 forbidden",
+		"invalid url",
+		"missing protocol scheme",
+	}
+	for _, s := range fatalSubstrings {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// connectRPC 使用指数退避（起始 500ms，倍增，±20% 抖动，上限 maxBackoff）持续重试拨号，
+// 瞬时错误无限重试，致命错误（鉴权失败、URL 格式错误等）立即返回。
+func connectRPC(chain, rpcURL string, maxBackoff time.Duration) (*ethclient.Client, error) {
 	var client *ethclient.Client
-	var err error
-	for i := 0; i < 5; i++ { // 最多重试 5 次
-		client, err = ethclient.Dial(rpcURL)
-		if err == nil {
-			log.Println("成功连接到 RPC")
-			return client, nil
+	operation := func() error {
+		c, err := ethclient.Dial(rpcURL)
+		if err != nil {
+			if isFatalRPCError(err) {
+				return backoff.Permanent(err)
+			}
+			return err
 		}
-		log.Printf("连接 RPC 失败（尝试 %d 次）：%v", i+1, err)
-		time.Sleep(5 * time.Second)
+		client = c
+		return nil
+	}
+	notify := func(err error, wait time.Duration) {
+		rpcRetriesTotal.WithLabelValues(chain, "dial").Inc()
+		log.Printf("[%s] 连接 RPC 失败，将在 %v 后重试：%v", chain, wait, err)
 	}
-	return nil, fmt.Errorf("多次尝试后无法连接 RPC：%v", err)
+	if err := backoff.RetryNotify(operation, newExponentialBackOff(maxBackoff), notify); err != nil {
+		return nil, fmt.Errorf("连接 RPC 失败（致命错误）：%v", err)
+	}
+	log.Printf("[%s] 成功连接到 RPC", chain)
+	return client, nil
 }
 
-func main() {
-	// 命令行参数
-	rpc := flag.String("rpc", "", "区块链的 RPC URL")
-	interval := flag.Int("interval", 3, "轮询间隔（秒）")
-	pushGateway := flag.String("pushgateway", "", "Pushgateway 地址")
-	flag.Parse()
+// fetchBlockNumber 在给定 context 的存活期内，以指数退避重试获取当前区块号；
+// 瞬时错误持续重试直至 ctx 超时，致命错误立即返回。
+func fetchBlockNumber(ctx context.Context, chain string, client *ethclient.Client, maxBackoff time.Duration) (uint64, error) {
+	var num uint64
+	operation := func() error {
+		n, err := client.BlockNumber(ctx)
+		if err != nil {
+			if isFatalRPCError(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		num = n
+		return nil
+	}
+	notify := func(err error, wait time.Duration) {
+		rpcRetriesTotal.WithLabelValues(chain, "block_number").Inc()
+		log.Printf("[%s] 获取当前区块号失败，将在 %v 后重试：%v", chain, wait, err)
+	}
+	err := backoff.RetryNotify(operation, backoff.WithContext(newExponentialBackOff(maxBackoff), ctx), notify)
+	return num, err
+}
 
-	// 检查必需的参数
-	if *rpc == "" || *pushGateway == "" {
-		log.Fatalf("rpc 和 pushgateway 参数是必需的")
+// fetchHeader 在给定 context 的存活期内，以指数退避重试获取指定高度的区块头。
+func fetchHeader(ctx context.Context, chain string, client *ethclient.Client, number *big.Int, maxBackoff time.Duration) (*types.Header, error) {
+	var header *types.Header
+	operation := func() error {
+		h, err := client.HeaderByNumber(ctx, number)
+		if err != nil {
+			if isFatalRPCError(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		header = h
+		return nil
+	}
+	notify := func(err error, wait time.Duration) {
+		rpcRetriesTotal.WithLabelValues(chain, "header_by_number").Inc()
+		log.Printf("[%s] 获取区块头失败，将在 %v 后重试：%v", chain, wait, err)
 	}
+	err := backoff.RetryNotify(operation, backoff.WithContext(newExponentialBackOff(maxBackoff), ctx), notify)
+	return header, err
+}
 
-	// 连接到区块链 RPC
-	client, err := connectRPC(*rpc)
-	if err != nil {
-		log.Fatalf("连接 RPC 失败：%v", err)
+// parseEndpoints 解析形如 "prime=url1,region0=url2,zone0-0=url3" 的 -rpc 参数，
+// 返回链/分片名称到 RPC URL 的映射，保留原始顺序以便日志输出稳定。
+func parseEndpoints(raw string) (map[string]string, []string, error) {
+	endpoints := make(map[string]string)
+	var order []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, nil, fmt.Errorf("无效的 -rpc 条目：%q，期望格式 name=url", part)
+		}
+		name := strings.TrimSpace(kv[0])
+		url := strings.TrimSpace(kv[1])
+		if _, exists := endpoints[name]; exists {
+			return nil, nil, fmt.Errorf("重复的链/分片名称：%q", name)
+		}
+		endpoints[name] = url
+		order = append(order, name)
 	}
-	// 使用 defer 正确关闭客户端，假设 Close() 无返回值
-	defer client.Close()
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("-rpc 参数中未找到任何有效的 name=url 条目")
+	}
+	return endpoints, order, nil
+}
 
-	// 设置 Prometheus 指标
-	difficultyGauge := prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "quai_network_current_block_difficulty",
-		Help: "当前区块的难度",
-	})
-	prometheus.MustRegister(difficultyGauge)
+// metrics 集中持有所有按链/分片打标签的指标，供各采集 goroutine 共用。
+type metrics struct {
+	difficulty       *prometheus.GaugeVec
+	blockHeight      *prometheus.GaugeVec
+	blockTime        *prometheus.GaugeVec
+	gasUsed          *prometheus.GaugeVec
+	backfillProgress *prometheus.GaugeVec
+}
 
-	// 设置定时器
-	ticker := time.NewTicker(time.Duration(*interval) * time.Second)
-	defer ticker.Stop()
+func newMetrics() *metrics {
+	m := &metrics{
+		difficulty: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "quai_network_current_block_difficulty",
+			Help: "当前区块的难度，按链/分片打标签",
+		}, []string{"chain"}),
+		blockHeight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "quai_network_block_height",
+			Help: "当前观测到的区块高度，按链/分片打标签",
+		}, []string{"chain"}),
+		blockTime: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "quai_network_block_timestamp",
+			Help: "当前区块的时间戳（unix 秒），按链/分片打标签",
+		}, []string{"chain"}),
+		gasUsed: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "quai_network_block_gas_used",
+			Help: "当前区块消耗的 gas，按链/分片打标签",
+		}, []string{"chain"}),
+		backfillProgress: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "quai_monitor_backfill_progress_ratio",
+			Help: "历史回填完成比例（0-1），按链/分片打标签",
+		}, []string{"chain"}),
+	}
+	prometheus.MustRegister(m.difficulty, m.blockHeight, m.blockTime, m.gasUsed, m.backfillProgress)
+	return m
+}
 
-	// 设置信号处理以实现优雅关闭
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+// difficultyBackfillHist 记录回填期间观测到的历史难度分布，避免用海量 block_number
+// 标签直接拖垮 Gauge 的基数。
+var difficultyBackfillHist = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "quai_network_backfill_difficulty",
+	Help:    "回填期间观测到的历史区块难度分布，按链/分片打标签",
+	Buckets: prometheus.ExponentialBuckets(1, 2, 20),
+}, []string{"chain"})
+
+func init() {
+	prometheus.MustRegister(difficultyBackfillHist)
+}
+
+// reorgsTotal 统计通过 WebSocket 订阅观测到的重组次数：当新收到的区块头的父哈希
+// 与上一次观测到的头哈希不一致时计数加一。
+var reorgsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "quai_monitor_reorgs_total",
+	Help: "按链/分片统计的重组次数",
+}, []string{"chain"})
+
+func init() {
+	prometheus.MustRegister(reorgsTotal)
+}
+
+// isWebSocketURL 判断 RPC 端点是否使用 ws://或 wss://，决定是否走订阅模式而非轮询。
+func isWebSocketURL(rpcURL string) bool {
+	return strings.HasPrefix(rpcURL, "ws://") || strings.HasPrefix(rpcURL, "wss://")
+}
+
+// handleNewHead 处理一个新收到（无论来自轮询还是订阅）的区块头：更新指标、检测重组、
+// 并在配置了 Pushgateway 时推送。重组检测依赖"头与头之间连续"的假设，只在
+// lastHeadHash 非空时启用——轮询模式下两次 tick 之间可能跳过多个区块，父哈希
+// 与上次观测到的头哈希不一致是常态而非重组，因此轮询调用方应传入 nil 禁用检测，
+// 只有按头订阅（WebSocket）才能保证逐块连续，传入非 nil 的 lastHeadHash 启用检测。
+func handleNewHead(chain string, header *types.Header, pushGateway string, m *metrics, lastHeadHash *string) {
+	if lastHeadHash != nil {
+		headHash := header.Hash().String()
+		if *lastHeadHash != "" && header.ParentHash().String() != *lastHeadHash {
+			reorgsTotal.WithLabelValues(chain).Inc()
+			log.Printf("[%s] 检测到重组：新区块头的父哈希与上一次观测到的头哈希不一致", chain)
+		}
+		*lastHeadHash = headHash
+	}
+
+	difficultyBig := header.Difficulty()
+	if difficultyBig == nil {
+		log.Printf("[%s] 新区块头的难度信息为空", chain)
+		return
+	}
+
+	blockNumber := header.Number().Uint64()
+	m.difficulty.WithLabelValues(chain).Set(float64(difficultyBig.Uint64()))
+	m.blockHeight.WithLabelValues(chain).Set(float64(blockNumber))
+	m.blockTime.WithLabelValues(chain).Set(float64(header.Time()))
+	m.gasUsed.WithLabelValues(chain).Set(float64(header.GasUsed()))
+	lastSuccessfulPoll.WithLabelValues(chain).Set(float64(time.Now().Unix()))
+
+	if pushGateway != "" {
+		// m.difficulty 等是所有链共用的 GaugeVec，Grouping 只决定 Pushgateway 里的分组
+		// key，并不会过滤要序列化的样本；如果直接把整个 Vec 作为 Collector 推送，
+		// 每条链的这次推送会把其它所有链当前的样本也一并带上。只推送这条链自己的
+		// 标签实例（WithLabelValues 返回的度量本身就是一个只包含自己的 Collector），
+		// 确保每条链的推送只包含它自己的样本。
+		err := push.New(pushGateway, "quai_current_difficulty").
+			Collector(m.difficulty.WithLabelValues(chain)).
+			Collector(m.blockHeight.WithLabelValues(chain)).
+			Collector(m.blockTime.WithLabelValues(chain)).
+			Collector(m.gasUsed.WithLabelValues(chain)).
+			Grouping("job", "quai").
+			Grouping("chain", chain).
+			Push()
+		if err != nil {
+			log.Printf("[%s] 推送指标失败：%v", chain, err)
+		} else {
+			log.Printf("[%s] 成功推送区块 %d 的难度：%d", chain, blockNumber, difficultyBig.Uint64())
+		}
+	}
+}
+
+// subscribeChain 通过 SubscribeNewHead 以推送方式接收新区块头，避免轮询在
+// interval 大于出块时间时漏块、小于出块时间时浪费 RPC 调用的两难取舍。
+// 订阅中断时按指数退避策略重新订阅，必要时重新拨号。
+func subscribeChain(chain, rpcURL string, maxBackoff time.Duration, pushGateway string, m *metrics, bf *backfillConfig, wg *sync.WaitGroup, onFatal func()) {
+	defer wg.Done()
+
+	client, err := connectRPC(chain, rpcURL, maxBackoff)
+	if err != nil {
+		log.Printf("[%s] 连接 RPC 失败，退出该链的采集：%v", chain, err)
+		onFatal()
+		return
+	}
+
+	if bf != nil {
+		if err := backfillChain(chain, client, *bf, m, difficultyBackfillHist); err != nil {
+			log.Printf("[%s] 历史回填失败：%v", chain, err)
+		}
+	}
+
+	b := newExponentialBackOff(maxBackoff)
+	var lastHeadHash string
 
-	// 主循环
 	for {
-		select {
-		case <-ticker.C:
-			// 创建带有超时的上下文
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-
-			// 获取当前区块号
-			currentBlockNumber, err := client.BlockNumber(ctx)
-			if err != nil {
-				log.Printf("获取当前区块号失败：%v", err)
-				cancel() // 显式取消上下文
-				continue
+		headers := make(chan *types.Header)
+		sub, err := client.SubscribeNewHead(context.Background(), headers)
+		if err != nil {
+			rpcRetriesTotal.WithLabelValues(chain, "subscribe").Inc()
+			wait := b.NextBackOff()
+			log.Printf("[%s] 订阅新区块头失败，将在 %v 后重试：%v", chain, wait, err)
+			time.Sleep(wait)
+			client.Close()
+			if client, err = connectRPC(chain, rpcURL, maxBackoff); err != nil {
+				log.Printf("[%s] 重新连接 RPC 失败，退出该链的采集：%v", chain, err)
+				onFatal()
+				return
 			}
+			continue
+		}
 
-			// 将 currentBlockNumber 转换为 *big.Int
-			blockNumberBig := new(big.Int).SetUint64(currentBlockNumber)
+		log.Printf("[%s] 已通过 WebSocket 订阅新区块头", chain)
+		b.Reset()
+		// 订阅中断期间错过的区块会让重连后收到的第一个头的父哈希与断线前的
+		// lastHeadHash 合法地对不上，重置为空以跳过这一次检测，避免误报重组。
+		lastHeadHash = ""
 
-			// 获取区块头
-			header, err := client.HeaderByNumber(ctx, blockNumberBig)
-			if err != nil {
-				log.Printf("获取区块 %d 的区块头失败：%v", currentBlockNumber, err)
-				cancel() // 显式取消上下文
-				continue
+		var subErr error
+	consume:
+		for {
+			select {
+			case subErr = <-sub.Err():
+				break consume
+			case header := <-headers:
+				handleNewHead(chain, header, pushGateway, m, &lastHeadHash)
 			}
+		}
+		sub.Unsubscribe()
 
-			// 获取难度
-			difficultyBig := header.Difficulty()
-			if difficultyBig == nil {
-				log.Printf("区块 %d 的难度信息为空", currentBlockNumber)
-				cancel()
-				continue
-			}
-			difficulty := difficultyBig.Uint64()
-
-			// 更新指标
-			difficultyGauge.Set(float64(difficulty))
-
-			// 推送指标到 Pushgateway
-			err = push.New(*pushGateway, "quai_current_difficulty").
-				Collector(difficultyGauge).
-				Grouping("job", "quai").
-				Push()
-			if err != nil {
-				log.Printf("推送指标失败：%v", err)
-			} else {
-				log.Printf("成功推送当前区块 %d 的难度：%d", currentBlockNumber, difficulty)
+		wait := b.NextBackOff()
+		log.Printf("[%s] 新区块头订阅中断，将在 %v 后重新订阅：%v", chain, wait, subErr)
+		time.Sleep(wait)
+	}
+}
+
+// pollChain 为单个链/分片独立运行采集循环：拥有自己的 ethclient.Client、ticker 和
+// context，某一条链的失败不会阻塞或影响其他链的采集。连续多次调用失败后会主动重拨连接。
+// 若 bf 非空，会先执行一轮历史回填，再进入常规的按 interval 轮询。
+func pollChain(chain, rpcURL string, interval, maxBackoff time.Duration, pushGateway string, m *metrics, bf *backfillConfig, wg *sync.WaitGroup, onFatal func()) {
+	defer wg.Done()
+
+	client, err := connectRPC(chain, rpcURL, maxBackoff)
+	if err != nil {
+		log.Printf("[%s] 连接 RPC 失败，退出该链的采集：%v", chain, err)
+		onFatal()
+		return
+	}
+	defer client.Close()
+
+	if bf != nil {
+		if err := backfillChain(chain, client, *bf, m, difficultyBackfillHist); err != nil {
+			log.Printf("[%s] 历史回填失败：%v", chain, err)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	consecutiveFailures := 0
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+
+		currentBlockNumber, err := fetchBlockNumber(ctx, chain, client, maxBackoff)
+		if err != nil {
+			log.Printf("[%s] 获取当前区块号失败：%v", chain, err)
+			cancel()
+			consecutiveFailures++
+			var fatal bool
+			if client, fatal = redialIfNeeded(chain, rpcURL, maxBackoff, client, &consecutiveFailures, onFatal); fatal {
+				return
 			}
+			continue
+		}
+
+		blockNumberBig := new(big.Int).SetUint64(currentBlockNumber)
 
-			// 取消上下文以释放资源
+		header, err := fetchHeader(ctx, chain, client, blockNumberBig, maxBackoff)
+		if err != nil {
+			log.Printf("[%s] 获取区块 %d 的区块头失败：%v", chain, currentBlockNumber, err)
 			cancel()
+			consecutiveFailures++
+			var fatal bool
+			if client, fatal = redialIfNeeded(chain, rpcURL, maxBackoff, client, &consecutiveFailures, onFatal); fatal {
+				return
+			}
+			continue
+		}
 
-		case <-quit:
-			log.Println("收到关闭信号，正在退出...")
-			return
+		consecutiveFailures = 0
+		// 轮询按 interval 定时触发，两次 tick 之间可能跳过多个区块，父哈希与上次
+		// 观测到的头哈希不一致是常态，因此传 nil 禁用重组检测（仅订阅模式启用）。
+		handleNewHead(chain, header, pushGateway, m, nil)
+		cancel()
+	}
+}
+
+// redialIfNeeded 在连续失败次数达到 redialThreshold 时关闭旧连接并重新拨号，
+// 避免无限期复用一个已经失效的连接；未达到阈值时原样返回旧的 client。connectRPC
+// 只会在遇到致命（不可重试）错误时才返回 error，因此重新拨号失败即意味着该链
+// 已无法恢复：调用 onFatal 计入致命链数，并通过 fatal=true 告知调用方停止该链的轮询，
+// 不能像此前那样默默返回旧的失效 client 继续空转。
+func redialIfNeeded(chain, rpcURL string, maxBackoff time.Duration, client *ethclient.Client, consecutiveFailures *int, onFatal func()) (*ethclient.Client, bool) {
+	if *consecutiveFailures < redialThreshold {
+		return client, false
+	}
+	log.Printf("[%s] 连续 %d 次调用失败，重新拨号 RPC 连接", chain, *consecutiveFailures)
+	client.Close()
+	newClient, err := connectRPC(chain, rpcURL, maxBackoff)
+	if err != nil {
+		log.Printf("[%s] 重新拨号失败（致命错误），停止该链的采集：%v", chain, err)
+		onFatal()
+		return nil, true
+	}
+	*consecutiveFailures = 0
+	return newClient, false
+}
+
+func main() {
+	// 命令行参数
+	rpc := flag.String("rpc", "", "区块链的 RPC URL 列表，格式为 name=url，多个之间用逗号分隔，例如 prime=...,region0=...,zone0-0=...")
+	interval := flag.Int("interval", 3, "轮询间隔（秒）")
+	pushGateway := flag.String("pushgateway", "", "Pushgateway 地址（可选，与 -listen 至少二选一）")
+	listen := flag.String("listen", "", "以 host:port 形式开启原生 /metrics HTTP 端点，供 Prometheus 直接抓取（可选，与 -pushgateway 至少二选一），例如 :9110")
+	maxBackoff := flag.Duration("max-backoff", 60*time.Second, "RPC 重连/重试指数退避的最大等待时间")
+	from := flag.Int64("from", -1, "历史回填的起始区块高度；不指定则不回填（除非指定 -catchup）")
+	catchup := flag.Bool("catchup", false, "启动时从上次持久化的高度（或 -from，取较大者）回填到当前链高度")
+	stateFile := flag.String("state-file", "quai-monitor-state.db", "持久化各链回填进度的 BoltDB 文件路径")
+	backfillWorkers := flag.Int("backfill-workers", 4, "历史回填时并发拉取区块头的 worker 数量")
+	flag.Parse()
+
+	// 检查必需的参数
+	if *rpc == "" {
+		log.Fatalf("rpc 参数是必需的")
+	}
+	if *pushGateway == "" && *listen == "" {
+		log.Fatalf("pushgateway 和 listen 参数至少需要指定一个")
+	}
+
+	endpoints, order, err := parseEndpoints(*rpc)
+	if err != nil {
+		log.Fatalf("解析 -rpc 参数失败：%v", err)
+	}
+
+	m := newMetrics()
+
+	if *listen != "" {
+		go serveMetrics(*listen)
+	}
+
+	var bf *backfillConfig
+	if *from >= 0 || *catchup {
+		store, err := openStateStore(*stateFile)
+		if err != nil {
+			log.Fatalf("打开回填进度文件失败：%v", err)
+		}
+		defer store.Close()
+		bf = &backfillConfig{
+			from:        *from,
+			catchup:     *catchup,
+			store:       store,
+			workers:     *backfillWorkers,
+			maxBackoff:  *maxBackoff,
+			pushGateway: *pushGateway,
+		}
+	}
+
+	// 设置信号处理以实现优雅关闭
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+
+	// fatalChains 统计因致命 RPC 错误（鉴权失败、URL 格式错误等）放弃采集的链数量；
+	// 一旦所有链都已放弃，继续运行已没有意义，进程应以非零状态退出，以便
+	// kube-prometheus-stack 之类的编排系统感知到故障并重启/告警，而不是悄悄空转到 SIGTERM。
+	var fatalChains int64
+	onFatal := func() {
+		if atomic.AddInt64(&fatalChains, 1) >= int64(len(order)) {
+			log.Fatalf("所有链均因致命错误放弃采集，退出进程")
 		}
 	}
+
+	var wg sync.WaitGroup
+	for _, chain := range order {
+		wg.Add(1)
+		url := endpoints[chain]
+		if isWebSocketURL(url) {
+			go subscribeChain(chain, url, *maxBackoff, *pushGateway, m, bf, &wg, onFatal)
+		} else {
+			go pollChain(chain, url, time.Duration(*interval)*time.Second, *maxBackoff, *pushGateway, m, bf, &wg, onFatal)
+		}
+	}
+
+	<-quit
+	log.Println("收到关闭信号，正在退出...")
 }